@@ -0,0 +1,145 @@
+package proto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Buffer is a reusable encode/decode scratch space, modeled on
+// github.com/golang/protobuf/proto's Buffer. Where Marshal always
+// allocates a fresh []byte sized to fit exactly one message, a Buffer
+// grows its backing slice in place, so a caller that encodes many
+// messages (e.g. a server writing thousands of responses) pays for
+// allocation only when the slice actually needs to grow.
+//
+// A Buffer is not safe for concurrent use.
+type Buffer struct {
+	buf []byte
+	off int // read cursor used by Unmarshal
+}
+
+// NewBuffer returns a Buffer that writes to, and reads from, buf.
+func NewBuffer(buf []byte) *Buffer {
+	return &Buffer{buf: buf}
+}
+
+// Marshal appends the encoded form of v to the Buffer, prefixed with its
+// length as a varint (the same framing EncodeRawBytes and stream.go use),
+// so that repeated Marshal calls produce a stream Unmarshal can walk back
+// through message by message via the read cursor. Raw protobuf is not
+// self-delimiting, so without this prefix concatenated messages could not
+// be told apart on the way back out.
+func (b *Buffer) Marshal(v interface{}) error {
+	t, p := inspect(v)
+	c := cachedCodecOf(t)
+	size := c.size(p, inline)
+
+	start := len(b.buf)
+	b.buf = growBuffer(b.buf, sizeOfVarint(uint64(size))+size)
+	off := start + copy(b.buf[start:], appendVarint(nil, uint64(size)))
+	if _, err := c.encode(b.buf[off:off+size], p, inline); err != nil {
+		b.buf = b.buf[:start]
+		return fmt.Errorf("proto.Buffer.Marshal(%T): %w", v, err)
+	}
+	return nil
+}
+
+// Unmarshal decodes the next length-prefixed message from the Buffer into
+// v, which must be a pointer, advancing the Buffer's read cursor past it
+// so a subsequent Unmarshal reads the next message in the stream.
+func (b *Buffer) Unmarshal(v interface{}) error {
+	rest := b.buf[b.off:]
+	if len(rest) == 0 {
+		return nil
+	}
+
+	size, n, err := consumeVarint(rest)
+	if err != nil {
+		return fmt.Errorf("proto.Buffer.Unmarshal: %w", err)
+	}
+	if uint64(len(rest)-n) < size {
+		return fmt.Errorf("proto.Buffer.Unmarshal: message size %d exceeds buffer", size)
+	}
+	msg := rest[n : n+int(size)]
+
+	t, p := inspect(v)
+	t = t.Elem() // Unmarshal must be passed a pointer
+	c := cachedCodecOf(t)
+
+	if _, err := c.decode(msg, p, noflags); err != nil {
+		return err
+	}
+	b.off += n + int(size)
+	return nil
+}
+
+// EncodeVarint appends v to the Buffer using the protobuf varint encoding.
+func (b *Buffer) EncodeVarint(v uint64) {
+	b.buf = appendVarint(b.buf, v)
+}
+
+// EncodeFixed32 appends v to the Buffer as 4 little-endian bytes.
+func (b *Buffer) EncodeFixed32(v uint32) {
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, v)
+}
+
+// EncodeFixed64 appends v to the Buffer as 8 little-endian bytes.
+func (b *Buffer) EncodeFixed64(v uint64) {
+	b.buf = binary.LittleEndian.AppendUint64(b.buf, v)
+}
+
+// EncodeRawBytes appends data to the Buffer prefixed with its length as a
+// varint, the representation protobuf uses for bytes and string fields.
+func (b *Buffer) EncodeRawBytes(data []byte) {
+	b.buf = appendVarint(b.buf, uint64(len(data)))
+	b.buf = append(b.buf, data...)
+}
+
+// Bytes returns the Buffer's full backing slice.
+func (b *Buffer) Bytes() []byte {
+	return b.buf
+}
+
+// SetBuf replaces the Buffer's contents with buf and resets the read
+// cursor to the start of it.
+func (b *Buffer) SetBuf(buf []byte) {
+	b.buf = buf
+	b.off = 0
+}
+
+// Reset empties the Buffer without releasing its backing array, so the
+// next Marshal call can reuse the capacity.
+func (b *Buffer) Reset() {
+	b.buf = b.buf[:0]
+	b.off = 0
+}
+
+// growBuffer extends buf by n bytes, reusing spare capacity when there is
+// enough, and returns the grown slice.
+func growBuffer(buf []byte, n int) []byte {
+	if cap(buf)-len(buf) >= n {
+		return buf[:len(buf)+n]
+	}
+	grown := make([]byte, len(buf)+n, 2*(cap(buf)+n))
+	copy(grown, buf)
+	return grown
+}
+
+// bufferPool lets high-throughput callers share Buffers instead of each
+// wiring up their own pool.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(Buffer) },
+}
+
+// GetBuffer returns a Buffer from the shared pool, ready for use.
+func GetBuffer() *Buffer {
+	return bufferPool.Get().(*Buffer)
+}
+
+// PutBuffer resets b and returns it to the shared pool. Callers must not
+// use b again after calling PutBuffer.
+func PutBuffer(b *Buffer) {
+	b.Reset()
+	bufferPool.Put(b)
+}
@@ -0,0 +1,105 @@
+package proto
+
+import "testing"
+
+// A handful of distinct struct types, so that marshaling all of them
+// forces cachedCodecOf to build (and cache) that many distinct codecs.
+type cacheBenchA struct{ X int32 }
+type cacheBenchB struct {
+	X int32
+	Y int32
+}
+type cacheBenchC struct{ X string }
+type cacheBenchD struct {
+	X string
+	Y string
+}
+type cacheBenchE struct{ X bool }
+type cacheBenchF struct {
+	X int64
+	Y string
+	Z bool
+}
+type cacheBenchG struct{ X float64 }
+type cacheBenchH struct {
+	X uint32
+	Y uint64
+}
+
+func cacheBenchValues() []interface{} {
+	return []interface{}{
+		&cacheBenchA{X: 1},
+		&cacheBenchB{X: 1, Y: 2},
+		&cacheBenchC{X: "hello"},
+		&cacheBenchD{X: "hello", Y: "world"},
+		&cacheBenchE{X: true},
+		&cacheBenchF{X: 1, Y: "hello", Z: true},
+		&cacheBenchG{X: 3.14},
+		&cacheBenchH{X: 1, Y: 2},
+	}
+}
+
+// BenchmarkCodeMarshalParallel marshals many distinct types concurrently,
+// exercising cachedCodecOf's Load fast path (and, on first use of each
+// type, its LoadOrStore path) under contention the way a large program
+// touching many message types from many goroutines would.
+func BenchmarkCodeMarshalParallel(b *testing.B) {
+	values := cacheBenchValues()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := Marshal(values[i%len(values)]); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+// BenchmarkCodeUnmarshalParallel is the Unmarshal counterpart of
+// BenchmarkCodeMarshalParallel.
+func BenchmarkCodeUnmarshalParallel(b *testing.B) {
+	values := cacheBenchValues()
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		data, err := Marshal(v)
+		if err != nil {
+			b.Fatal(err)
+		}
+		encoded[i] = data
+	}
+
+	newDst := func(i int) interface{} {
+		switch values[i].(type) {
+		case *cacheBenchA:
+			return new(cacheBenchA)
+		case *cacheBenchB:
+			return new(cacheBenchB)
+		case *cacheBenchC:
+			return new(cacheBenchC)
+		case *cacheBenchD:
+			return new(cacheBenchD)
+		case *cacheBenchE:
+			return new(cacheBenchE)
+		case *cacheBenchF:
+			return new(cacheBenchF)
+		case *cacheBenchG:
+			return new(cacheBenchG)
+		default:
+			return new(cacheBenchH)
+		}
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			idx := i % len(values)
+			if err := Unmarshal(encoded[idx], newDst(idx)); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
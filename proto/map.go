@@ -0,0 +1,262 @@
+package proto
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// standaloneMapField is the field number used to tag entries when a map
+// is encoded with no surrounding struct field context (a bare
+// Marshal/Unmarshal of a map value), where there is no real field number
+// to use.
+const standaloneMapField fieldNumber = 1
+
+// mapCodecOf builds the codec for a Go map type, representing it the way
+// proto3 represents a `map<K, V>` field: as a sequence of independently
+// framed entries, each the synthetic message `{key=1, value=2}`, using
+// the element codecs codecOf already knows how to build for K and V. A
+// nil or empty map encodes as zero bytes.
+//
+// The returned codec is selfFramed: its encode method writes complete,
+// individually tagged `tag(N,LEN) len {key,value}` entries, one per map
+// pair, rather than a single length-delimited blob - this is what makes
+// it wire-compatible with a standard proto3 map<K,V> field, whose entries
+// are repeated occurrences of the same field tag, not one blob containing
+// all of them. N is the containing struct field's number, packed into the
+// flags passed to size/encode/decode via withFieldNumber; the struct
+// codec must pass it that way and must not itself add a tag/length
+// wrapper around a selfFramed field, since one entry's tag already serves
+// that purpose for each pair. Called with no packed field number (e.g. a
+// standalone Marshal(aMap)), entries are tagged with standaloneMapField.
+func mapCodecOf(t reflect.Type, seen map[reflect.Type]*codec) *codec {
+	c := new(codec)
+	seen[t] = c
+
+	keyType, valType := t.Key(), t.Elem()
+	if !mapKeyKindSupported(keyType.Kind()) {
+		panic("unsupported map key type: " + keyType.String())
+	}
+	keyCodec := codecOf(keyType, seen)
+	valCodec := codecOf(valType, seen)
+
+	entrySize := func(kp, vp unsafe.Pointer) int {
+		return sizeOfTag(1) + sizeOfField(keyCodec, kp) + sizeOfTag(2) + sizeOfField(valCodec, vp)
+	}
+
+	encodeEntry := func(b []byte, kp, vp unsafe.Pointer) (int, error) {
+		off := append2(b, 0, appendTag(nil, 1, keyCodec.wire))
+		n, err := encodeField(b, off, keyCodec, kp)
+		if err != nil {
+			return 0, fmt.Errorf("proto: map key: %w", err)
+		}
+		off = n
+
+		off = append2(b, off, appendTag(nil, 2, valCodec.wire))
+		n, err = encodeField(b, off, valCodec, vp)
+		if err != nil {
+			return 0, fmt.Errorf("proto: map value: %w", err)
+		}
+		return n, nil
+	}
+
+	*c = codec{
+		wire:       varlen,
+		selfFramed: true,
+
+		size: func(p unsafe.Pointer, f flags) int {
+			if f.has(inline) {
+				p = unsafe.Pointer(&p) // p is the map itself, not a *map
+			}
+			m := reflect.NewAt(t, p).Elem()
+			if m.Len() == 0 {
+				return 0
+			}
+			entryField := f.packedFieldNumber()
+			if entryField == 0 {
+				entryField = standaloneMapField
+			}
+			n := 0
+			iter := m.MapRange()
+			for iter.Next() {
+				kp, vp := addrOf(iter.Key()), addrOf(iter.Value())
+				size := entrySize(kp, vp)
+				n += sizeOfTag(entryField) + sizeOfVarint(uint64(size)) + size
+			}
+			return n
+		},
+
+		encode: func(b []byte, p unsafe.Pointer, f flags) (int, error) {
+			if f.has(inline) {
+				p = unsafe.Pointer(&p) // p is the map itself, not a *map
+			}
+			m := reflect.NewAt(t, p).Elem()
+			if m.Len() == 0 {
+				return 0, nil
+			}
+			entryField := f.packedFieldNumber()
+			if entryField == 0 {
+				entryField = standaloneMapField
+			}
+			off := 0
+			iter := m.MapRange()
+			for iter.Next() {
+				kp, vp := addrOf(iter.Key()), addrOf(iter.Value())
+				size := entrySize(kp, vp)
+
+				off = append2(b, off, appendTag(nil, entryField, varlen))
+				off = append2(b, off, appendVarint(nil, uint64(size)))
+				n, err := encodeEntry(b[off:off+size], kp, vp)
+				if err != nil {
+					return 0, err
+				}
+				off += n
+			}
+			return off, nil
+		},
+
+		decode: func(b []byte, p unsafe.Pointer, f flags) (int, error) {
+			entryField := f.packedFieldNumber()
+			if entryField == 0 {
+				entryField = standaloneMapField
+			}
+			m := reflect.NewAt(t, p).Elem()
+			off := 0
+			for off < len(b) {
+				num, wire, n, err := consumeTag(b[off:])
+				if err != nil {
+					return 0, fmt.Errorf("proto: map: %w", err)
+				}
+				off += n
+				if num != entryField || wire != varlen {
+					return 0, fmt.Errorf("proto: map: unexpected field %d (%s)", num, wire)
+				}
+
+				size, n, err := consumeVarint(b[off:])
+				if err != nil {
+					return 0, fmt.Errorf("proto: map: %w", err)
+				}
+				off += n
+				if uint64(len(b)-off) < size {
+					return 0, fmt.Errorf("proto: map: truncated entry")
+				}
+				entry := b[off : off+int(size)]
+				off += int(size)
+
+				kv := reflect.New(keyType)
+				vv := reflect.New(valType)
+				eoff := 0
+				for eoff < len(entry) {
+					fnum, fwire, fn, err := consumeTag(entry[eoff:])
+					if err != nil {
+						return 0, fmt.Errorf("proto: map entry: %w", err)
+					}
+					eoff += fn
+
+					switch fnum {
+					case 1:
+						n, err := decodeField(entry[eoff:], fwire, keyCodec.decode, kv.UnsafePointer())
+						if err != nil {
+							return 0, fmt.Errorf("proto: map key: %w", err)
+						}
+						eoff += n
+					case 2:
+						n, err := decodeField(entry[eoff:], fwire, valCodec.decode, vv.UnsafePointer())
+						if err != nil {
+							return 0, fmt.Errorf("proto: map value: %w", err)
+						}
+						eoff += n
+					default:
+						return 0, fmt.Errorf("proto: map entry: unexpected field number %d", fnum)
+					}
+				}
+
+				// A key or value field absent from the entry keeps its
+				// proto3 zero value, same as a missing field anywhere
+				// else; key and value may also appear in either order.
+				if m.IsNil() {
+					m.Set(reflect.MakeMapWithSize(t, 1))
+				}
+				m.SetMapIndex(kv.Elem(), vv.Elem())
+			}
+			return off, nil
+		},
+	}
+	return c
+}
+
+// mapKeyKindSupported reports whether k is one of the Go kinds accepted as a
+// proto3 map key: int, int32, int64, uint, uint32, uint64, bool, or string.
+// The other integer kinds (int8, int16, uint8, uint16, uintptr) are not
+// accepted.
+func mapKeyKindSupported(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+// addrOf copies v into freshly allocated, addressable storage and returns a
+// pointer to it. Map keys and values obtained from reflect.Value.MapRange
+// are not addressable, so codecs that expect an unsafe.Pointer to the value
+// need a real location to point at.
+func addrOf(v reflect.Value) unsafe.Pointer {
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p.UnsafePointer()
+}
+
+// append2 writes tag into b starting at off and returns the new offset.
+func append2(b []byte, off int, tag []byte) int {
+	return off + copy(b[off:], tag)
+}
+
+// sizeOfField returns the number of bytes c would write for the value at p,
+// including the length prefix varlen wire types carry on the wire.
+func sizeOfField(c *codec, p unsafe.Pointer) int {
+	size := c.size(p, noflags)
+	if c.wire == varlen {
+		size += sizeOfVarint(uint64(size))
+	}
+	return size
+}
+
+// encodeField writes the value at p into b starting at off, prefixing it
+// with a length varint when c's wire type requires one, and returns the new
+// offset.
+func encodeField(b []byte, off int, c *codec, p unsafe.Pointer) (int, error) {
+	size := c.size(p, noflags)
+	if c.wire == varlen {
+		off = append2(b, off, appendVarint(nil, uint64(size)))
+	}
+	n, err := c.encode(b[off:off+size], p, noflags)
+	if err != nil {
+		return 0, err
+	}
+	return off + n, nil
+}
+
+// decodeField reads one field's value out of b, which starts immediately
+// after the field's tag. varlen fields carry their own length prefix;
+// other wire types are self-delimiting and decode determines how much of b
+// it consumed.
+func decodeField(b []byte, wire wireType, decode decodeFunc, p unsafe.Pointer) (int, error) {
+	if wire != varlen {
+		return decode(b, p, noflags)
+	}
+	size, n, err := consumeVarint(b)
+	if err != nil {
+		return 0, err
+	}
+	if uint64(len(b)-n) < size {
+		return 0, fmt.Errorf("proto: truncated field")
+	}
+	if _, err := decode(b[n:n+int(size)], p, noflags); err != nil {
+		return 0, err
+	}
+	return n + int(size), nil
+}
@@ -0,0 +1,111 @@
+package proto
+
+import "reflect"
+
+// Merge copies fields from src into dst following proto3 merge semantics:
+// non-zero scalar fields overwrite, message fields merge recursively, and
+// repeated fields append. Both dst and src must be pointers to the same
+// struct type.
+func Merge(dst, src interface{}) {
+	dv := reflect.ValueOf(dst)
+	sv := reflect.ValueOf(src)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		panic("proto: Merge called with nil or non-pointer dst")
+	}
+	if sv.Kind() != reflect.Ptr || sv.IsNil() {
+		return
+	}
+	mergeValue(dv.Elem(), sv.Elem())
+}
+
+// Clone returns a deep copy of v, which must be a pointer.
+func Clone(v interface{}) interface{} {
+	sv := reflect.ValueOf(v)
+	if sv.Kind() != reflect.Ptr {
+		panic("proto: Clone called with non-pointer")
+	}
+	dv := reflect.New(sv.Type().Elem())
+	if !sv.IsNil() {
+		mergeValue(dv.Elem(), sv.Elem())
+	}
+	return dv.Interface()
+}
+
+// mergeValue merges src into dst in place, recursing into struct fields,
+// pointed-to values, slice elements, and map entries so that message-typed
+// elements are deep-copied rather than shared with src.
+func mergeValue(dst, src reflect.Value) {
+	switch dst.Kind() {
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			df := dst.Field(i)
+			if !df.CanSet() {
+				continue // unexported field: nothing Merge/Clone can copy
+			}
+			mergeValue(df, src.Field(i))
+		}
+
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		mergeValue(dst.Elem(), src.Elem())
+
+	case reflect.Slice:
+		if src.Len() == 0 {
+			return
+		}
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, src.Len())
+			reflect.Copy(reflect.ValueOf(b), src)
+			dst.SetBytes(b)
+			return
+		}
+		n := dst.Len()
+		grown := reflect.MakeSlice(dst.Type(), n+src.Len(), n+src.Len())
+		reflect.Copy(grown, dst)
+		for i := 0; i < src.Len(); i++ {
+			mergeValue(grown.Index(n+i), src.Index(i))
+		}
+		dst.Set(grown)
+
+	case reflect.Map:
+		if src.Len() == 0 {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(dst.Type(), src.Len()))
+		}
+		elemNeedsCopy := mapValueNeedsDeepCopy(dst.Type().Elem().Kind())
+		iter := src.MapRange()
+		for iter.Next() {
+			if !elemNeedsCopy {
+				dst.SetMapIndex(iter.Key(), iter.Value())
+				continue
+			}
+			nv := reflect.New(dst.Type().Elem()).Elem()
+			mergeValue(nv, iter.Value())
+			dst.SetMapIndex(iter.Key(), nv)
+		}
+
+	default: // bool, every int/uint/float kind, string
+		if !src.IsZero() {
+			dst.Set(src)
+		}
+	}
+}
+
+// mapValueNeedsDeepCopy reports whether a map value of kind k can itself
+// hold shared references (pointers, nested messages, slices, or maps) and
+// so must be merged element-by-element rather than copied by assignment.
+func mapValueNeedsDeepCopy(k reflect.Kind) bool {
+	switch k {
+	case reflect.Ptr, reflect.Struct, reflect.Slice, reflect.Map:
+		return true
+	default:
+		return false
+	}
+}
@@ -3,7 +3,7 @@ package proto
 import (
 	"fmt"
 	"reflect"
-	"sync/atomic"
+	"sync"
 	"unsafe"
 )
 
@@ -59,6 +59,11 @@ const (
 	noflags  flags = 0
 	inline   flags = 1 << 0
 	wantzero flags = 1 << 1
+
+	// fieldNumberShift reserves the low bits of flags for the boolean
+	// flags above; a self-framed codec's caller packs the field it is
+	// encoding into the remaining high bits with withFieldNumber.
+	fieldNumberShift = 8
 )
 
 func (f flags) has(x flags) bool {
@@ -73,6 +78,18 @@ func (f flags) without(x flags) flags {
 	return f & ^x
 }
 
+// withFieldNumber returns f with n packed into its high bits, alongside
+// whatever boolean flags f already carries.
+func withFieldNumber(f flags, n fieldNumber) flags {
+	return f&(1<<fieldNumberShift-1) | flags(n)<<fieldNumberShift
+}
+
+// packedFieldNumber returns the field number packed into f by
+// withFieldNumber, or 0 if none was packed.
+func (f flags) packedFieldNumber() fieldNumber {
+	return fieldNumber(f >> fieldNumberShift)
+}
+
 type iface struct {
 	typ unsafe.Pointer
 	ptr unsafe.Pointer
@@ -130,32 +147,34 @@ type codec struct {
 	size   sizeFunc
 	encode encodeFunc
 	decode decodeFunc
-}
-
-var codecCache atomic.Value // map[unsafe.Pointer]*codec
 
-func loadCachedCodec(t reflect.Type) (*codec, map[unsafe.Pointer]*codec) {
-	cache, _ := codecCache.Load().(map[unsafe.Pointer]*codec)
-	return cache[pointer(t)], cache
+	// selfFramed is true for codecs whose encode method already emits
+	// zero or more complete, individually tagged wire entries by itself
+	// (currently only mapCodecOf). A struct codec embedding a
+	// selfFramed field must pass the field's real number in via
+	// withFieldNumber and must not additionally wrap the result in its
+	// own tag/length, since the codec already did - wrapping it again
+	// would double-frame the entries and leave them tagged with the
+	// wrong field number.
+	selfFramed bool
 }
 
-func storeCachedCodec(t reflect.Type, oldCache map[unsafe.Pointer]*codec, newCodec *codec) {
-	newCache := make(map[unsafe.Pointer]*codec, len(oldCache)+1)
-	for p, c := range oldCache {
-		newCache[p] = c
-	}
-	newCache[pointer(t)] = newCodec
-	codecCache.Store(newCache)
-}
+// codecCache maps reflect.Type to *codec. Reads go through the fast,
+// lock-free Load path; a new type only takes the slower LoadOrStore path
+// once, after which every goroutine observes the cached codec without
+// ever copying the whole cache.
+var codecCache sync.Map // map[reflect.Type]*codec
 
 func cachedCodecOf(t reflect.Type) *codec {
-	c, m := loadCachedCodec(t)
-	if c != nil {
-		return c
+	if c, ok := codecCache.Load(t); ok {
+		return c.(*codec)
 	}
-	c = codecOf(t, make(map[reflect.Type]*codec))
-	storeCachedCodec(t, m, c)
-	return c
+	// seen is local to this build so that codecs for types still under
+	// construction (recursive structs) never escape into the global
+	// cache half-built.
+	c := codecOf(t, make(map[reflect.Type]*codec))
+	actual, _ := codecCache.LoadOrStore(t, c)
+	return actual.(*codec)
 }
 
 func codecOf(t reflect.Type, seen map[reflect.Type]*codec) *codec {
@@ -213,6 +232,9 @@ func codecOf(t reflect.Type, seen map[reflect.Type]*codec) *codec {
 
 	case reflect.Ptr:
 		return pointerCodecOf(t, seen)
+
+	case reflect.Map:
+		return mapCodecOf(t, seen)
 	}
 	panic("unsupported type: " + t.String())
-}
\ No newline at end of file
+}
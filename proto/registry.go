@@ -0,0 +1,138 @@
+package proto
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// typesByName and namesByType back RegisterType, letting a message be
+// looked up by the name it was registered under and, in the other
+// direction, letting MarshalAny recover the name for a concrete value.
+var (
+	typesByName sync.Map // map[string]reflect.Type
+	namesByType sync.Map // map[reflect.Type]string
+)
+
+// RegisterType associates name with the type of v, so that MessageType,
+// MarshalAny, and UnmarshalAny can recover the concrete Go type for
+// messages whose type is only known at runtime by name - the mechanism
+// behind google.protobuf.Any and any RPC system that multiplexes
+// heterogeneous messages over one channel.
+func RegisterType(name string, v interface{}) {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	typesByName.Store(name, t)
+	namesByType.Store(t, name)
+}
+
+// MessageType returns the type registered under name, or nil if no type
+// was registered under that name.
+func MessageType(name string) reflect.Type {
+	v, _ := typesByName.Load(name)
+	t, _ := v.(reflect.Type)
+	return t
+}
+
+// MarshalAny encodes v as a google.protobuf.Any-style message,
+// {type_url=1:string, value=2:bytes}, where value is the result of
+// Marshal(v). The concrete type of v must have been registered with
+// RegisterType first.
+func MarshalAny(v interface{}) ([]byte, error) {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name, ok := namesByType.Load(t)
+	if !ok {
+		return nil, fmt.Errorf("proto.MarshalAny(%T): type not registered", v)
+	}
+	typeURL := name.(string)
+
+	value, err := Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("proto.MarshalAny(%T): %w", v, err)
+	}
+
+	size := sizeOfTag(1) + sizeOfVarint(uint64(len(typeURL))) + len(typeURL)
+	size += sizeOfTag(2) + sizeOfVarint(uint64(len(value))) + len(value)
+
+	b := make([]byte, 0, size)
+	b = appendTag(b, 1, varlen)
+	b = appendVarint(b, uint64(len(typeURL)))
+	b = append(b, typeURL...)
+	b = appendTag(b, 2, varlen)
+	b = appendVarint(b, uint64(len(value)))
+	b = append(b, value...)
+	return b, nil
+}
+
+// UnmarshalAny is the inverse of MarshalAny. When typeURL is empty, data
+// is taken to be a full Any envelope as produced by MarshalAny, and the
+// type URL and value are recovered from it. When typeURL is non-empty,
+// data is taken to already be the bare inner message (the envelope's
+// value field), decoded directly against the given typeURL - useful when
+// the two travelled separately (e.g. typeURL came from an RPC header).
+// Either way, the type named by the resulting typeURL must have been
+// registered with RegisterType.
+func UnmarshalAny(data []byte, typeURL string) (interface{}, error) {
+	value := data
+	if typeURL == "" {
+		var err error
+		typeURL, value, err = unwrapAny(data)
+		if err != nil {
+			return nil, fmt.Errorf("proto.UnmarshalAny: %w", err)
+		}
+	}
+
+	t := MessageType(typeURL)
+	if t == nil {
+		return nil, fmt.Errorf("proto.UnmarshalAny: type %q is not registered", typeURL)
+	}
+
+	v := reflect.New(t)
+	if err := Unmarshal(value, v.Interface()); err != nil {
+		return nil, fmt.Errorf("proto.UnmarshalAny(%s): %w", typeURL, err)
+	}
+	return v.Interface(), nil
+}
+
+// unwrapAny parses a google.protobuf.Any-style envelope,
+// {type_url=1:string, value=2:bytes}, as produced by MarshalAny.
+func unwrapAny(data []byte) (typeURL string, value []byte, err error) {
+	off := 0
+	for off < len(data) {
+		num, wire, n, err := consumeTag(data[off:])
+		if err != nil {
+			return "", nil, err
+		}
+		off += n
+		if wire != varlen {
+			return "", nil, fmt.Errorf("unexpected wire type for field %d: %s", num, wire)
+		}
+
+		size, n, err := consumeVarint(data[off:])
+		if err != nil {
+			return "", nil, err
+		}
+		off += n
+		if uint64(len(data)-off) < size {
+			return "", nil, fmt.Errorf("truncated field %d", num)
+		}
+		field := data[off : off+int(size)]
+		off += int(size)
+
+		switch num {
+		case 1:
+			typeURL = string(field)
+		case 2:
+			value = field
+		}
+	}
+	if typeURL == "" {
+		return "", nil, fmt.Errorf("envelope is missing type_url")
+	}
+	return typeURL, value, nil
+}
@@ -0,0 +1,118 @@
+package proto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// defaultMaxMessageSize is the largest length-prefixed message a Decoder
+// will accept before SetMaxMessageSize is called to raise or lower it.
+const defaultMaxMessageSize = 64 << 20 // 64 MiB
+
+// scratchPool holds reusable byte slices shared by Encoders so that a
+// program creating many short-lived Encoders doesn't grow a fresh buffer
+// for every one of them.
+var scratchPool = sync.Pool{
+	New: func() interface{} { b := make([]byte, 0, 512); return &b },
+}
+
+// Encoder writes a stream of length-delimited messages to an io.Writer,
+// analogous to gob.Encoder. Unlike a raw proto.Marshal, the length prefix
+// makes the stream self-delimiting so multiple messages can be written to
+// (and later read back from) the same io.Writer/io.Reader.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes v to the underlying writer as a varint length prefix
+// followed by its encoded form.
+func (e *Encoder) Encode(v interface{}) error {
+	t, p := inspect(v)
+	c := cachedCodecOf(t)
+	size := c.size(p, inline)
+
+	bufp := scratchPool.Get().(*[]byte)
+	defer scratchPool.Put(bufp)
+
+	need := binary.MaxVarintLen64 + size
+	if cap(*bufp) < need {
+		*bufp = make([]byte, need)
+	}
+	b := (*bufp)[:need]
+
+	n := binary.PutUvarint(b, uint64(size))
+	if _, err := c.encode(b[n:n+size], p, inline); err != nil {
+		return fmt.Errorf("proto.Encoder.Encode(%T): %w", v, err)
+	}
+
+	_, err := e.w.Write(b[:n+size])
+	return err
+}
+
+// Decoder reads a stream of length-delimited messages written by an
+// Encoder, analogous to gob.Decoder.
+type Decoder struct {
+	r       *bufio.Reader
+	buf     []byte
+	maxSize int
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), maxSize: defaultMaxMessageSize}
+}
+
+// SetMaxMessageSize bounds the size of a single message that Decode will
+// accept, guarding against a corrupt or malicious length prefix causing
+// an unbounded allocation.
+func (d *Decoder) SetMaxMessageSize(n int) {
+	d.maxSize = n
+}
+
+// Decode reads the next length-delimited message from the stream into v,
+// which must be a pointer. It returns io.EOF, with nothing read, when the
+// stream ends exactly on a message boundary, matching gob.Decoder.
+func (d *Decoder) Decode(v interface{}) error {
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return fmt.Errorf("proto.Decoder.Decode: %w", err)
+	}
+	if d.maxSize < 0 || size > uint64(d.maxSize) {
+		return fmt.Errorf("proto.Decoder.Decode: message size %d exceeds max message size %d", size, d.maxSize)
+	}
+
+	if cap(d.buf) < int(size) {
+		d.buf = make([]byte, size)
+	}
+	b := d.buf[:size]
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("proto.Decoder.Decode: %w", err)
+	}
+
+	t, p := inspect(v)
+	t = t.Elem() // Decode must be passed a pointer
+	c := cachedCodecOf(t)
+
+	n, err := c.decode(b, p, noflags)
+	if err != nil {
+		return err
+	}
+	if n < len(b) {
+		return fmt.Errorf("proto.Decoder.Decode(%T): read=%d < buffer=%d", v, n, len(b))
+	}
+	return nil
+}
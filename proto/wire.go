@@ -0,0 +1,60 @@
+package proto
+
+import "fmt"
+
+// appendVarint appends v to b using the protobuf base-128 varint encoding.
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// sizeOfVarint returns the number of bytes appendVarint would write for v.
+func sizeOfVarint(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		n++
+		v >>= 7
+	}
+	return n
+}
+
+// consumeVarint reads a varint from the front of b, returning its value and
+// the number of bytes consumed.
+func consumeVarint(b []byte) (v uint64, n int, err error) {
+	for shift := uint(0); shift < 64; shift += 7 {
+		if n >= len(b) {
+			return 0, 0, fmt.Errorf("proto: truncated varint")
+		}
+		c := b[n]
+		n++
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v, n, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("proto: varint overflows 64 bits")
+}
+
+// appendTag appends the (field number, wire type) tag that prefixes every
+// field value on the wire.
+func appendTag(b []byte, n fieldNumber, wire wireType) []byte {
+	return appendVarint(b, uint64(n)<<3|uint64(wire))
+}
+
+// sizeOfTag returns the number of bytes appendTag would write for n.
+func sizeOfTag(n fieldNumber) int {
+	return sizeOfVarint(uint64(n) << 3)
+}
+
+// consumeTag reads a tag from the front of b, returning the field number,
+// wire type, and the number of bytes consumed.
+func consumeTag(b []byte) (n fieldNumber, wire wireType, size int, err error) {
+	v, size, err := consumeVarint(b)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return fieldNumber(v >> 3), wireType(v & 7), size, nil
+}